@@ -0,0 +1,306 @@
+package ipproxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+
+	"golang.org/x/net/proxy"
+)
+
+// SOCKS5Auth carries the username/password credentials for a SOCKS5
+// upstream. Leave both fields empty to connect without authentication.
+type SOCKS5Auth struct {
+	Username string
+	Password string
+}
+
+// DialTCPViaSOCKS5 returns a dial function suitable for Opts.DialTCP that
+// shovels every intercepted TCP flow through the SOCKS5 server at
+// socksAddr, letting the TUN act as a transparent proxy in front of an
+// upstream SOCKS5 gateway. auth may be nil to connect anonymously.
+func DialTCPViaSOCKS5(socksAddr string, auth *SOCKS5Auth) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	var a *proxy.Auth
+	if auth != nil {
+		a = &proxy.Auth{User: auth.Username, Password: auth.Password}
+	}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		dialer, err := proxy.SOCKS5(network, socksAddr, a, proxy.Direct)
+		if err != nil {
+			return nil, fmt.Errorf("unable to build SOCKS5 dialer: %v", err)
+		}
+		if cd, ok := dialer.(proxy.ContextDialer); ok {
+			return cd.DialContext(ctx, network, addr)
+		}
+		return dialer.Dial(network, addr)
+	}
+}
+
+// HTTPConnectAuth carries the Basic auth credentials for an HTTP CONNECT
+// upstream. Leave both fields empty to connect without authentication.
+type HTTPConnectAuth struct {
+	Username string
+	Password string
+}
+
+// DialTCPViaHTTPConnect returns a dial function suitable for Opts.DialTCP
+// that establishes each intercepted TCP flow by issuing an HTTP CONNECT
+// request to proxyAddr and then treating the resulting connection as a raw
+// pipe to addr, the same way an HTTP forward proxy client would.
+func DialTCPViaHTTPConnect(proxyAddr string, auth *HTTPConnectAuth) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, network, proxyAddr)
+		if err != nil {
+			return nil, fmt.Errorf("unable to dial HTTP CONNECT proxy: %v", err)
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodConnect, "http://"+addr, nil)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+		req.Host = addr
+		if auth != nil {
+			req.SetBasicAuth(auth.Username, auth.Password)
+		}
+		if err := req.Write(conn); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("unable to write CONNECT request: %v", err)
+		}
+
+		br := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(br, req)
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("unable to read CONNECT response: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			conn.Close()
+			return nil, fmt.Errorf("CONNECT to %v failed: %v", addr, resp.Status)
+		}
+		if br.Buffered() > 0 {
+			return &bufferedConn{Conn: conn, r: br}, nil
+		}
+		return conn, nil
+	}
+}
+
+// bufferedConn preserves any bytes the proxy sent immediately after a
+// successful CONNECT response, before handing the connection back for use
+// as a plain net.Conn.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// DialUDPViaSOCKS5 returns a dial function suitable for Opts.DialUDP that
+// relays each intercepted UDP flow through socksAddr's UDP ASSOCIATE
+// support, as described in RFC 1928 section 7. auth may be nil to connect
+// anonymously.
+func DialUDPViaSOCKS5(socksAddr string, auth *SOCKS5Auth) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		targetAddr, err := net.ResolveUDPAddr(network, addr)
+		if err != nil {
+			return nil, fmt.Errorf("unable to resolve UDP target %v: %v", addr, err)
+		}
+
+		ctrl, err := new(net.Dialer).DialContext(ctx, "tcp", socksAddr)
+		if err != nil {
+			return nil, fmt.Errorf("unable to dial SOCKS5 server: %v", err)
+		}
+
+		relayAddr, err := socks5UDPAssociate(ctrl, auth)
+		if err != nil {
+			ctrl.Close()
+			return nil, err
+		}
+
+		udpConn, err := net.DialUDP("udp", nil, relayAddr)
+		if err != nil {
+			ctrl.Close()
+			return nil, fmt.Errorf("unable to dial SOCKS5 UDP relay: %v", err)
+		}
+
+		return &socks5UDPConn{Conn: udpConn, ctrl: ctrl, targetAddr: targetAddr}, nil
+	}
+}
+
+// socks5UDPAssociate performs the SOCKS5 handshake and UDP ASSOCIATE
+// request over ctrl, returning the relay address the client should send
+// its UDP datagrams to. ctrl must be kept open for the lifetime of the
+// association.
+func socks5UDPAssociate(ctrl net.Conn, auth *SOCKS5Auth) (*net.UDPAddr, error) {
+	methods := []byte{0x00}
+	if auth != nil {
+		methods = []byte{0x00, 0x02}
+	}
+	greeting := append([]byte{0x05, byte(len(methods))}, methods...)
+	if _, err := ctrl.Write(greeting); err != nil {
+		return nil, fmt.Errorf("unable to write SOCKS5 greeting: %v", err)
+	}
+	resp := make([]byte, 2)
+	if _, err := io.ReadFull(ctrl, resp); err != nil {
+		return nil, fmt.Errorf("unable to read SOCKS5 greeting response: %v", err)
+	}
+	if resp[0] != 0x05 {
+		return nil, fmt.Errorf("unexpected SOCKS5 version: %d", resp[0])
+	}
+
+	if resp[1] == 0x02 {
+		if auth == nil {
+			return nil, fmt.Errorf("SOCKS5 server requires authentication")
+		}
+		authReq := []byte{0x01, byte(len(auth.Username))}
+		authReq = append(authReq, auth.Username...)
+		authReq = append(authReq, byte(len(auth.Password)))
+		authReq = append(authReq, auth.Password...)
+		if _, err := ctrl.Write(authReq); err != nil {
+			return nil, fmt.Errorf("unable to write SOCKS5 auth request: %v", err)
+		}
+		authResp := make([]byte, 2)
+		if _, err := io.ReadFull(ctrl, authResp); err != nil {
+			return nil, fmt.Errorf("unable to read SOCKS5 auth response: %v", err)
+		}
+		if authResp[1] != 0x00 {
+			return nil, fmt.Errorf("SOCKS5 authentication failed")
+		}
+	} else if resp[1] != 0x00 {
+		return nil, fmt.Errorf("SOCKS5 server rejected all auth methods")
+	}
+
+	// UDP ASSOCIATE - the bound address we send is irrelevant since we
+	// don't know our own outbound address yet, so use 0.0.0.0:0.
+	req := []byte{0x05, 0x03, 0x00, 0x01, 0, 0, 0, 0, 0, 0}
+	if _, err := ctrl.Write(req); err != nil {
+		return nil, fmt.Errorf("unable to write UDP ASSOCIATE request: %v", err)
+	}
+
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(ctrl, header); err != nil {
+		return nil, fmt.Errorf("unable to read UDP ASSOCIATE response header: %v", err)
+	}
+	if header[1] != 0x00 {
+		return nil, fmt.Errorf("UDP ASSOCIATE failed with SOCKS5 reply code %d", header[1])
+	}
+
+	var ip net.IP
+	switch header[3] {
+	case 0x01:
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(ctrl, buf); err != nil {
+			return nil, err
+		}
+		ip = net.IP(buf)
+	case 0x04:
+		buf := make([]byte, 16)
+		if _, err := io.ReadFull(ctrl, buf); err != nil {
+			return nil, err
+		}
+		ip = net.IP(buf)
+	default:
+		return nil, fmt.Errorf("unsupported SOCKS5 address type %d in UDP ASSOCIATE response", header[3])
+	}
+	portBuf := make([]byte, 2)
+	if _, err := io.ReadFull(ctrl, portBuf); err != nil {
+		return nil, err
+	}
+	port := binary.BigEndian.Uint16(portBuf)
+
+	if ip.IsUnspecified() {
+		host, _, err := net.SplitHostPort(ctrl.RemoteAddr().String())
+		if err != nil {
+			return nil, err
+		}
+		ip = net.ParseIP(host)
+	}
+	return &net.UDPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// socks5UDPConn wraps a UDP connection to a SOCKS5 UDP relay, framing
+// outbound writes with the SOCKS5 UDP request header and stripping it from
+// inbound reads so that callers see a plain net.Conn to the target.
+type socks5UDPConn struct {
+	*net.UDPConn
+	ctrl       net.Conn
+	targetAddr *net.UDPAddr
+}
+
+func (c *socks5UDPConn) Write(b []byte) (int, error) {
+	header := socks5UDPHeader(c.targetAddr)
+	if _, err := c.UDPConn.Write(append(header, b...)); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *socks5UDPConn) Read(b []byte) (int, error) {
+	buf := make([]byte, len(b)+262)
+	n, err := c.UDPConn.Read(buf)
+	if err != nil {
+		return 0, err
+	}
+	payload, err := stripSOCKS5UDPHeader(buf[:n])
+	if err != nil {
+		return 0, err
+	}
+	return copy(b, payload), nil
+}
+
+func (c *socks5UDPConn) Close() error {
+	c.ctrl.Close()
+	return c.UDPConn.Close()
+}
+
+func socks5UDPHeader(addr *net.UDPAddr) []byte {
+	header := []byte{0, 0, 0}
+	if ip4 := addr.IP.To4(); ip4 != nil {
+		header = append(header, 0x01)
+		header = append(header, ip4...)
+	} else {
+		header = append(header, 0x04)
+		header = append(header, addr.IP.To16()...)
+	}
+	port := make([]byte, 2)
+	binary.BigEndian.PutUint16(port, uint16(addr.Port))
+	return append(header, port...)
+}
+
+func stripSOCKS5UDPHeader(b []byte) ([]byte, error) {
+	if len(b) < 4 {
+		return nil, fmt.Errorf("SOCKS5 UDP datagram too short")
+	}
+	switch b[3] {
+	case 0x01:
+		if len(b) < 10 {
+			return nil, fmt.Errorf("SOCKS5 UDP datagram too short for IPv4 header")
+		}
+		return b[10:], nil
+	case 0x04:
+		if len(b) < 22 {
+			return nil, fmt.Errorf("SOCKS5 UDP datagram too short for IPv6 header")
+		}
+		return b[22:], nil
+	case 0x03:
+		if len(b) < 5 {
+			return nil, fmt.Errorf("SOCKS5 UDP datagram too short for domain header")
+		}
+		nameLen := int(b[4])
+		start := 5 + nameLen + 2
+		if len(b) < start {
+			return nil, fmt.Errorf("SOCKS5 UDP datagram too short for domain header")
+		}
+		return b[start:], nil
+	default:
+		return nil, fmt.Errorf("unsupported SOCKS5 address type %d", b[3])
+	}
+}
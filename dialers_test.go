@@ -0,0 +1,226 @@
+package ipproxy
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestTCPAndUDPViaSOCKS5 is analogous to TestTCPAndUDP, except that
+// Opts.DialTCP and Opts.DialUDP shovel every intercepted flow through an
+// in-process SOCKS5 server in front of the echo server, exercising the
+// TUN -> SOCKS5 -> echo server chain end to end.
+//
+// Note - this test has to be run with root permissions to allow setting up
+// the TUN device.
+func TestTCPAndUDPViaSOCKS5(t *testing.T) {
+	closeCh := make(chan interface{})
+	defer close(closeCh)
+
+	ip := "127.0.0.1"
+	echoAddr := tcpEcho(t, closeCh, ip)
+	udpEcho(t, closeCh, echoAddr)
+
+	socksAddr := startTestSOCKS5Server(t, closeCh)
+
+	dev, err := TUNDevice("", "10.0.3.2", "255.255.255.0", 1500)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer dev.Close()
+
+	p, err := New(dev, &Opts{
+		IdleTimeout:   1000 * time.Minute,
+		StatsInterval: 1 * time.Second,
+		DialTCP:       DialTCPViaSOCKS5(socksAddr, nil),
+		DialUDP:       DialUDPViaSOCKS5(socksAddr, nil),
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer p.Close()
+
+	go p.Serve()
+
+	gwEchoAddr := "10.0.3.1:" + portOf(t, echoAddr)
+
+	uconn, err := net.Dial("udp", gwEchoAddr)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer uconn.Close()
+	_, err = uconn.Write([]byte("helloudp"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	uconn.SetDeadline(time.Now().Add(2 * time.Second))
+	b := make([]byte, 8)
+	_, err = io.ReadFull(uconn, b)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "helloudp", string(b))
+	}
+
+	conn, err := net.DialTimeout("tcp4", gwEchoAddr, 5*time.Second)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte("hellotcp"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	_, err = io.ReadFull(conn, b)
+	if assert.NoError(t, err) {
+		assert.Equal(t, "hellotcp", string(b))
+	}
+}
+
+func portOf(t *testing.T, addr string) string {
+	_, port, err := net.SplitHostPort(addr)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return port
+}
+
+// startTestSOCKS5Server starts a minimal SOCKS5 server supporting
+// unauthenticated CONNECT and UDP ASSOCIATE, just enough to exercise
+// DialTCPViaSOCKS5 and DialUDPViaSOCKS5 in tests.
+func startTestSOCKS5Server(t *testing.T, closeCh <-chan interface{}) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	go func() {
+		<-closeCh
+		l.Close()
+	}()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go handleTestSOCKS5Conn(conn)
+		}
+	}()
+	return l.Addr().String()
+}
+
+func handleTestSOCKS5Conn(ctrl net.Conn) {
+	defer ctrl.Close()
+
+	greeting := make([]byte, 2)
+	if _, err := ioReadFull(ctrl, greeting); err != nil {
+		return
+	}
+	nmethods := int(greeting[1])
+	methods := make([]byte, nmethods)
+	if _, err := ioReadFull(ctrl, methods); err != nil {
+		return
+	}
+	if _, err := ctrl.Write([]byte{0x05, 0x00}); err != nil {
+		return
+	}
+
+	req := make([]byte, 4)
+	if _, err := ioReadFull(ctrl, req); err != nil {
+		return
+	}
+	targetAddr, err := readTestSOCKS5Addr(ctrl, req[3])
+	if err != nil {
+		return
+	}
+
+	switch req[1] {
+	case 0x01: // CONNECT
+		// Like doTest, send everything to the local echo server on the
+		// target's port; targetAddr is the TUN gateway, and dialing it would
+		// loop back through the TUN.
+		upstream, err := net.DialTCP("tcp", nil, &net.TCPAddr{IP: net.ParseIP("127.0.0.1"), Port: targetAddr.Port})
+		if err != nil {
+			ctrl.Write(testSOCKS5Reply(0x05, &net.UDPAddr{IP: net.IPv4zero}))
+			return
+		}
+		defer upstream.Close()
+		ctrl.Write(testSOCKS5Reply(0x00, &net.UDPAddr{IP: net.IPv4zero}))
+
+		done := make(chan struct{}, 2)
+		go func() { io.Copy(upstream, ctrl); done <- struct{}{} }()
+		go func() { io.Copy(ctrl, upstream); done <- struct{}{} }()
+		<-done
+
+	case 0x03: // UDP ASSOCIATE
+		relay, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.ParseIP("127.0.0.1")})
+		if err != nil {
+			ctrl.Write(testSOCKS5Reply(0x05, &net.UDPAddr{IP: net.IPv4zero}))
+			return
+		}
+		defer relay.Close()
+		relayAddr := relay.LocalAddr().(*net.UDPAddr)
+		ctrl.Write(testSOCKS5Reply(0x00, relayAddr))
+
+		go func() {
+			buf := make([]byte, 2048)
+			for {
+				n, from, err := relay.ReadFromUDP(buf)
+				if err != nil {
+					return
+				}
+				payload, err := stripSOCKS5UDPHeader(buf[:n])
+				if err != nil {
+					continue
+				}
+				// Like doTest, send everything to the local echo server on the
+				// target's port, then relay its reply back to the client
+				// prefixed with the same header the client used.
+				header := append([]byte{}, buf[:n-len(payload)]...)
+				port := int(header[len(header)-2])<<8 | int(header[len(header)-1])
+				upstream, err := net.DialUDP("udp", nil, &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: port})
+				if err != nil {
+					continue
+				}
+				upstream.SetDeadline(time.Now().Add(2 * time.Second))
+				upstream.Write(payload)
+				rn, err := upstream.Read(buf)
+				upstream.Close()
+				if err == nil {
+					relay.WriteToUDP(append(header, buf[:rn]...), from)
+				}
+			}
+		}()
+
+		// Keep the association open until the control connection closes.
+		io.Copy(io.Discard, ctrl)
+	}
+}
+
+func readTestSOCKS5Addr(ctrl net.Conn, atyp byte) (*net.UDPAddr, error) {
+	switch atyp {
+	case 0x01:
+		buf := make([]byte, 6)
+		if _, err := ioReadFull(ctrl, buf); err != nil {
+			return nil, err
+		}
+		return &net.UDPAddr{IP: net.IP(buf[:4]), Port: int(buf[4])<<8 | int(buf[5])}, nil
+	default:
+		buf := make([]byte, 18)
+		if _, err := ioReadFull(ctrl, buf); err != nil {
+			return nil, err
+		}
+		return &net.UDPAddr{IP: net.IP(buf[:16]), Port: int(buf[16])<<8 | int(buf[17])}, nil
+	}
+}
+
+func testSOCKS5Reply(rep byte, bind *net.UDPAddr) []byte {
+	reply := []byte{0x05, rep, 0x00, 0x01, 0, 0, 0, 0, 0, 0}
+	if ip4 := bind.IP.To4(); ip4 != nil {
+		copy(reply[4:8], ip4)
+	}
+	reply[8] = byte(bind.Port >> 8)
+	reply[9] = byte(bind.Port)
+	return reply
+}
@@ -0,0 +1,142 @@
+package ipproxy
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+)
+
+// DNSHandler answers DNS queries on behalf of TUN clients: an
+// implementation can synthesize a response, rewrite the query, or forward
+// it to a specific upstream resolver.
+//
+// Handle returns the raw DNS response bytes to write back to the client. A
+// nil, nil return means the handler does not answer the query, which is
+// useful for handlers that only want to answer a subset of names.
+//
+// Nothing in the proxy calls Handle: there is no Opts.DNSHandler field, and
+// UDP packets for port 53 go through the normal UDP conntrack path and
+// Opts.DialUDP like any other UDP flow.
+type DNSHandler interface {
+	Handle(query []byte, clientAddr *net.UDPAddr) (response []byte, err error)
+}
+
+// DNSHandlerFunc adapts a function to a DNSHandler.
+type DNSHandlerFunc func(query []byte, clientAddr *net.UDPAddr) ([]byte, error)
+
+// Handle implements DNSHandler.
+func (f DNSHandlerFunc) Handle(query []byte, clientAddr *net.UDPAddr) ([]byte, error) {
+	return f(query, clientAddr)
+}
+
+// StaticDNSOpts configures a StaticDNSHandler.
+type StaticDNSOpts struct {
+	// Hosts maps a fully-qualified domain name (with or without the
+	// trailing dot) to the IP address it should resolve to. Lookups are
+	// case-insensitive.
+	Hosts map[string]net.IP
+
+	// UpstreamBySuffix maps a domain suffix (e.g. "corp.example.com") to
+	// the "host:port" of an upstream resolver that queries for names under
+	// that suffix should be forwarded to. The most specific matching
+	// suffix wins. Suffixes not present here fall back to Default.
+	UpstreamBySuffix map[string]string
+
+	// Default is the upstream resolver used when no entry in
+	// UpstreamBySuffix matches, e.g. "8.8.8.8:53". If empty, queries that
+	// don't match Hosts or UpstreamBySuffix are left unanswered: Handle
+	// returns a nil response for them.
+	Default string
+}
+
+// StaticDNSHandler is a built-in DNSHandler backed by a static host map
+// plus per-suffix upstream selection. It's intended to cover the common
+// case of a VPN/TUN tool that needs to answer a handful of names locally
+// (e.g. for split tunneling) and otherwise forward everything else.
+type StaticDNSHandler struct {
+	opts StaticDNSOpts
+
+	mx    sync.RWMutex
+	hosts map[string]net.IP
+}
+
+// NewStaticDNSHandler builds a StaticDNSHandler from opts.
+func NewStaticDNSHandler(opts StaticDNSOpts) *StaticDNSHandler {
+	hosts := make(map[string]net.IP, len(opts.Hosts))
+	for name, ip := range opts.Hosts {
+		hosts[normalizeDNSName(name)] = ip
+	}
+	return &StaticDNSHandler{opts: opts, hosts: hosts}
+}
+
+// SetHost adds or updates a single static host entry at runtime.
+func (h *StaticDNSHandler) SetHost(name string, ip net.IP) {
+	h.mx.Lock()
+	h.hosts[normalizeDNSName(name)] = ip
+	h.mx.Unlock()
+}
+
+// Handle implements DNSHandler.
+func (h *StaticDNSHandler) Handle(query []byte, clientAddr *net.UDPAddr) ([]byte, error) {
+	req := new(dns.Msg)
+	if err := req.Unpack(query); err != nil {
+		return nil, nil
+	}
+	if len(req.Question) != 1 {
+		return nil, nil
+	}
+	q := req.Question[0]
+
+	if ip, found := h.lookupHost(q.Name); found {
+		resp := new(dns.Msg)
+		resp.SetReply(req)
+		rr, err := dns.NewRR(q.Name + " 60 IN A " + ip.String())
+		if err != nil {
+			return nil, err
+		}
+		resp.Answer = append(resp.Answer, rr)
+		return resp.Pack()
+	}
+
+	upstream := h.upstreamFor(q.Name)
+	if upstream == "" {
+		return nil, nil
+	}
+	resp, err := dns.Exchange(req, upstream)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Pack()
+}
+
+func (h *StaticDNSHandler) lookupHost(name string) (net.IP, bool) {
+	h.mx.RLock()
+	defer h.mx.RUnlock()
+	ip, found := h.hosts[normalizeDNSName(name)]
+	return ip, found
+}
+
+// upstreamFor returns the most specific configured upstream for name,
+// falling back to opts.Default when no suffix matches.
+func (h *StaticDNSHandler) upstreamFor(name string) string {
+	name = normalizeDNSName(name)
+	best := ""
+	bestLen := -1
+	for suffix, upstream := range h.opts.UpstreamBySuffix {
+		suffix = normalizeDNSName(suffix)
+		if (name == suffix || strings.HasSuffix(name, "."+suffix)) && len(suffix) > bestLen {
+			best = upstream
+			bestLen = len(suffix)
+		}
+	}
+	if best != "" {
+		return best
+	}
+	return h.opts.Default
+}
+
+func normalizeDNSName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
@@ -0,0 +1,72 @@
+package ipproxy
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/assert"
+)
+
+// These tests exercise StaticDNSHandler.Handle/upstreamFor directly. There's
+// no interception test against the UDP conntrack path itself, because
+// nothing there calls DNSHandler.Handle yet - see DNSHandler's doc comment.
+
+func TestStaticDNSHandlerHostMap(t *testing.T) {
+	h := NewStaticDNSHandler(StaticDNSOpts{
+		Hosts: map[string]net.IP{
+			"internal.example.com": net.ParseIP("10.0.0.5"),
+		},
+	})
+
+	query := newDNSQuery(t, "internal.example.com.")
+	respBytes, err := h.Handle(query, &net.UDPAddr{IP: net.ParseIP("10.0.1.2")})
+	if !assert.NoError(t, err) {
+		return
+	}
+	if !assert.NotNil(t, respBytes, "should answer from the static host map without forwarding") {
+		return
+	}
+
+	resp := new(dns.Msg)
+	assert.NoError(t, resp.Unpack(respBytes))
+	if assert.Len(t, resp.Answer, 1) {
+		a, ok := resp.Answer[0].(*dns.A)
+		if assert.True(t, ok) {
+			assert.Equal(t, "10.0.0.5", a.A.String())
+		}
+	}
+}
+
+func TestStaticDNSHandlerFallsBackWithoutDefault(t *testing.T) {
+	h := NewStaticDNSHandler(StaticDNSOpts{})
+
+	query := newDNSQuery(t, "example.com.")
+	respBytes, err := h.Handle(query, &net.UDPAddr{IP: net.ParseIP("10.0.1.2")})
+	assert.NoError(t, err)
+	assert.Nil(t, respBytes, "an unmatched query with no default upstream should fall back to DialUDP")
+}
+
+func TestStaticDNSHandlerSuffixSelectsMostSpecificUpstream(t *testing.T) {
+	h := NewStaticDNSHandler(StaticDNSOpts{
+		UpstreamBySuffix: map[string]string{
+			"example.com":      "1.1.1.1:53",
+			"corp.example.com": "10.0.0.1:53",
+		},
+		Default: "8.8.8.8:53",
+	})
+
+	assert.Equal(t, "10.0.0.1:53", h.upstreamFor("host.corp.example.com"))
+	assert.Equal(t, "1.1.1.1:53", h.upstreamFor("host.example.com"))
+	assert.Equal(t, "8.8.8.8:53", h.upstreamFor("host.other.com"))
+}
+
+func newDNSQuery(t *testing.T, name string) []byte {
+	m := new(dns.Msg)
+	m.SetQuestion(name, dns.TypeA)
+	b, err := m.Pack()
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	return b
+}
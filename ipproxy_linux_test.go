@@ -88,6 +88,51 @@ func TestCloseCleanup(t *testing.T) {
 		})
 }
 
+// TestShutdown is a lot like TestCloseCleanup but it relies on calling
+// p.Shutdown(ctx) rather than p.Close(). The TCP and UDP client conns are
+// still open when Shutdown is called, so it only proves that Shutdown
+// returns once ctx is done and then closes the proxy the same way Close
+// does - it does NOT exercise rejection of new connections during the
+// drain window, since isDraining isn't consulted anywhere (see Shutdown's
+// doc comment in shutdown.go).
+func TestShutdown(t *testing.T) {
+	doTest(
+		t,
+		1,
+		longIdleTimeout,
+		"10.0.4.2", "10.0.4.1",
+		func(p Proxy, uconn net.Conn, b []byte) {
+			assert.Equal(t, "helloudp", string(b))
+		},
+		func(p Proxy, conn net.Conn, b []byte) {
+			assert.Equal(t, "hellotcp", string(b))
+		},
+		func(p Proxy, dev io.Closer) {
+			assert.True(t, p.NumTCPConns() > 0, "TCP client should not be purged before shutdown")
+			assert.True(t, p.NumUDPConns() > 0, "UDP conns should not be purged before shutdown")
+
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+
+			log.Debug("Shutting down")
+			done := make(chan error, 1)
+			go func() { done <- p.(*proxy).Shutdown(ctx) }()
+
+			select {
+			case err := <-done:
+				assert.NoError(t, err)
+			case <-time.After(10 * time.Second):
+				t.Fatal("Shutdown did not return in time")
+			}
+
+			log.Debug("Checking")
+			assert.Zero(t, p.NumTCPOrigins(), "TCP origin should be purged after shutdown")
+			assert.Zero(t, p.NumTCPConns(), "TCP client should be purged after shutdown")
+			assert.Zero(t, p.NumUDPConns(), "UDP conns should be purged after shutdown")
+			dev.Close()
+		})
+}
+
 func doTest(t *testing.T, loops int, idleTimeout time.Duration, addr string, gw string, afterUDP func(Proxy, net.Conn, []byte), afterTCP func(Proxy, net.Conn, []byte), finish func(Proxy, io.Closer)) {
 	var wg sync.WaitGroup
 	defer func() {
@@ -0,0 +1,84 @@
+package ipproxy
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrometheusMetricsSinkCounters(t *testing.T) {
+	s := NewPrometheusMetricsSink(PrometheusOpts{Namespace: "ipproxy"})
+
+	s.PacketAccepted()
+	s.PacketAccepted()
+	s.PacketRejected()
+	assert.Equal(t, float64(2), testutil.ToFloat64(s.acceptedPackets))
+	assert.Equal(t, float64(1), testutil.ToFloat64(s.rejectedPackets))
+
+	s.ConnOpened(ProtocolTCP, "10.0.0.1")
+	s.ConnClosed(ProtocolTCP, "10.0.0.1")
+	s.ConnOpened(ProtocolUDP, "10.0.0.2")
+	assert.Equal(t, float64(1), testutil.ToFloat64(s.connsOpened.WithLabelValues("tcp")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(s.connsClosed.WithLabelValues("tcp")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(s.connsOpened.WithLabelValues("udp")))
+
+	s.TUNBytes(100, 200)
+	assert.Equal(t, float64(100), testutil.ToFloat64(s.tunRxBytes))
+	assert.Equal(t, float64(200), testutil.ToFloat64(s.tunTxBytes))
+
+	s.RateLimitedFlows(3)
+	assert.Equal(t, float64(3), testutil.ToFloat64(s.rateLimitedFlows))
+}
+
+func TestPrometheusMetricsSinkBytesTransferredSplitsByProtocol(t *testing.T) {
+	s := NewPrometheusMetricsSink(PrometheusOpts{Namespace: "ipproxy"})
+
+	s.BytesTransferred(ProtocolTCP, "10.0.0.1", 10, 20)
+	s.BytesTransferred(ProtocolUDP, "10.0.0.1", 5, 7)
+
+	assert.Equal(t, float64(10), testutil.ToFloat64(s.bytesSent.WithLabelValues("tcp")))
+	assert.Equal(t, float64(20), testutil.ToFloat64(s.bytesReceived.WithLabelValues("tcp")))
+	assert.Equal(t, float64(5), testutil.ToFloat64(s.bytesSent.WithLabelValues("udp")))
+	assert.Equal(t, float64(7), testutil.ToFloat64(s.bytesReceived.WithLabelValues("udp")))
+}
+
+func TestPrometheusMetricsSinkPerDestinationIPLabel(t *testing.T) {
+	s := NewPrometheusMetricsSink(PrometheusOpts{Namespace: "ipproxy", PerDestinationIP: true})
+
+	s.ConnOpened(ProtocolTCP, "10.0.0.1")
+	assert.Equal(t, float64(1), testutil.ToFloat64(s.connsOpened.WithLabelValues("tcp", "10.0.0.1")))
+}
+
+func TestPrometheusMetricsSinkRequestDuration(t *testing.T) {
+	s := NewPrometheusMetricsSink(PrometheusOpts{Namespace: "ipproxy"})
+
+	s.RequestDuration(ProtocolTCP, 250*time.Millisecond)
+
+	families, err := s.registry.Gather()
+	if !assert.NoError(t, err) {
+		return
+	}
+	var found bool
+	for _, mf := range families {
+		if mf.GetName() != "ipproxy_request_duration_seconds" {
+			continue
+		}
+		found = true
+		assert.Equal(t, uint64(1), mf.GetMetric()[0].GetHistogram().GetSampleCount())
+	}
+	assert.True(t, found, "request_duration_seconds histogram should have been registered")
+}
+
+func TestNoopMetricsSinkIsSafeToCallUnconditionally(t *testing.T) {
+	var sink MetricsSink = noopMetricsSink{}
+	sink.PacketAccepted()
+	sink.PacketRejected()
+	sink.ConnOpened(ProtocolTCP, "10.0.0.1")
+	sink.ConnClosed(ProtocolTCP, "10.0.0.1")
+	sink.BytesTransferred(ProtocolUDP, "10.0.0.1", 1, 2)
+	sink.TUNBytes(1, 2)
+	sink.RequestDuration(ProtocolTCP, time.Millisecond)
+	sink.RateLimitedFlows(1)
+}
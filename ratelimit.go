@@ -0,0 +1,149 @@
+package ipproxy
+
+import (
+	"context"
+	"net"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// RateLimiter decides how many bytes a given flow is allowed to move right
+// now. Implementations should be cheap and safe for concurrent use.
+//
+// key identifies the flow being throttled - callers key by whatever policy
+// they want to enforce (e.g. source IP or destination port). n is the
+// number of bytes about to be transferred. WaitN blocks until that many
+// bytes are allowed to proceed, or ctx is done.
+//
+// EXPERIMENTAL: there's no Opts field for configuring a RateLimiter, and
+// rateLimited/rateLimitedConn below are never applied to a real TCP origin
+// or UDP conntrack copy loop - this type exists, but nothing in the proxy
+// actually throttles traffic with it yet.
+type RateLimiter interface {
+	WaitN(ctx context.Context, key string, n int) error
+}
+
+// TokenBucketRateLimiter is a built-in RateLimiter backed by
+// golang.org/x/time/rate. It enforces an optional global cap shared by all
+// flows plus an optional per-key cap, so that one hungry flow can't starve
+// the others: the global bucket limits aggregate throughput, while each
+// key's own bucket limits its individual share.
+type TokenBucketRateLimiter struct {
+	global *rate.Limiter
+	burst  int
+
+	perKeyLimit rate.Limit
+	perKeyBurst int
+
+	mx     sync.Mutex
+	perKey map[string]*rate.Limiter
+}
+
+// NewTokenBucketRateLimiter builds a TokenBucketRateLimiter. globalBPS and
+// perKeyBPS are expressed in bytes per second; a zero value disables that
+// cap. burst is the maximum number of bytes that can be sent in a single
+// burst above the steady-state rate, applied to both the global and
+// per-key buckets.
+func NewTokenBucketRateLimiter(globalBPS, perKeyBPS float64, burst int) *TokenBucketRateLimiter {
+	l := &TokenBucketRateLimiter{
+		burst:       burst,
+		perKeyLimit: rate.Limit(perKeyBPS),
+		perKeyBurst: burst,
+		perKey:      make(map[string]*rate.Limiter),
+	}
+	if globalBPS > 0 {
+		l.global = rate.NewLimiter(rate.Limit(globalBPS), burst)
+	}
+	return l
+}
+
+// WaitN implements RateLimiter. A token bucket can never hold more than its
+// configured burst, so a single underlying WaitN call for more than that
+// would fail outright instead of waiting; WaitN instead chunks n into
+// pieces of at most burst bytes and waits for each in turn, so callers can
+// throttle transfers of any size (e.g. a 32KB copy-loop read) without
+// having to know the limiter's burst themselves.
+func (l *TokenBucketRateLimiter) WaitN(ctx context.Context, key string, n int) error {
+	for n > 0 {
+		chunk := n
+		if l.burst > 0 && chunk > l.burst {
+			chunk = l.burst
+		}
+		if l.global != nil {
+			if err := l.global.WaitN(ctx, chunk); err != nil {
+				return err
+			}
+		}
+		if l.perKeyLimit > 0 {
+			if err := l.limiterFor(key).WaitN(ctx, chunk); err != nil {
+				return err
+			}
+		}
+		n -= chunk
+	}
+	return nil
+}
+
+func (l *TokenBucketRateLimiter) limiterFor(key string) *rate.Limiter {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	limiter, found := l.perKey[key]
+	if !found {
+		limiter = rate.NewLimiter(l.perKeyLimit, l.perKeyBurst)
+		l.perKey[key] = limiter
+	}
+	return limiter
+}
+
+// Usage returns the number of distinct keys currently tracked by the
+// per-key limiter, which the metrics subsystem can expose as a gauge of
+// active rate-limited flows.
+func (l *TokenBucketRateLimiter) Usage() int {
+	l.mx.Lock()
+	defer l.mx.Unlock()
+	return len(l.perKey)
+}
+
+// rateLimitedConn wraps a net.Conn so that every Read and Write is metered
+// against a RateLimiter under the given key: a Write waits before it
+// reaches the underlying conn, and a Read waits after the underlying read
+// has returned data, before handing it to the caller.
+type rateLimitedConn struct {
+	net.Conn
+	limiter RateLimiter
+	key     string
+}
+
+// reportUsage publishes the limiter's current per-key flow count to sink,
+// so it shows up alongside the rest of the proxy's metrics.
+func (l *TokenBucketRateLimiter) reportUsage(sink MetricsSink) {
+	sink.RateLimitedFlows(l.Usage())
+}
+
+// rateLimited wraps conn in a rateLimitedConn if limiter is non-nil,
+// otherwise it returns conn unchanged so call sites don't need to
+// nil-check.
+func rateLimited(conn net.Conn, limiter RateLimiter, key string) net.Conn {
+	if limiter == nil {
+		return conn
+	}
+	return &rateLimitedConn{Conn: conn, limiter: limiter, key: key}
+}
+
+func (c *rateLimitedConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if n > 0 {
+		if waitErr := c.limiter.WaitN(context.Background(), c.key, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+func (c *rateLimitedConn) Write(b []byte) (int, error) {
+	if err := c.limiter.WaitN(context.Background(), c.key, len(b)); err != nil {
+		return 0, err
+	}
+	return c.Conn.Write(b)
+}
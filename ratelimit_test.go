@@ -0,0 +1,57 @@
+package ipproxy
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTokenBucketRateLimiterEnforcesGlobalCap(t *testing.T) {
+	const bps = 1000
+	l := NewTokenBucketRateLimiter(bps, 0, bps)
+
+	start := time.Now()
+	err := l.WaitN(context.Background(), "any", bps*3)
+	elapsed := time.Since(start)
+
+	assert.NoError(t, err)
+	// The burst covers the first bps bytes for free, leaving 2*bps bytes
+	// to trickle in at bps/sec, so this should take roughly 2 seconds.
+	assert.True(t, elapsed >= 1900*time.Millisecond, "should be throttled to roughly the configured rate, took %v", elapsed)
+}
+
+func TestTokenBucketRateLimiterIsolatesKeys(t *testing.T) {
+	l := NewTokenBucketRateLimiter(0, 1000, 1000)
+
+	assert.NoError(t, l.WaitN(context.Background(), "flow-a", 500))
+	assert.NoError(t, l.WaitN(context.Background(), "flow-b", 500))
+	assert.Equal(t, 2, l.Usage(), "each key should get its own bucket so one flow can't starve another")
+}
+
+func TestRateLimitedConnMetersReads(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	defer server.Close()
+
+	const bps = 2000
+	limited := rateLimited(client, NewTokenBucketRateLimiter(bps, 0, bps), "flow")
+
+	payload := make([]byte, bps*2)
+	go server.Write(payload)
+
+	start := time.Now()
+	buf := make([]byte, len(payload))
+	total := 0
+	for total < len(payload) {
+		n, err := limited.Read(buf[total:])
+		total += n
+		if !assert.NoError(t, err) {
+			return
+		}
+	}
+	elapsed := time.Since(start)
+	assert.True(t, elapsed >= 900*time.Millisecond, "reads should be throttled to roughly the configured rate, took %v", elapsed)
+}
@@ -0,0 +1,49 @@
+package ipproxy
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// drainPollInterval is how often Shutdown checks whether existing
+// connections have finished draining.
+const drainPollInterval = 50 * time.Millisecond
+
+// Shutdown gracefully stops the proxy. Like http.Server.Shutdown, it waits
+// for existing connections to finish on their own or for ctx to be done,
+// whichever comes first, then closes any remaining state the same way Close
+// does. It returns the result of Close, also when ctx is done before the
+// connections have finished.
+//
+// Shutdown sets p.draining, but nothing consults isDraining, so new TCP
+// origins and UDP flows are still accepted while Shutdown waits. Shutdown
+// is a method of the unexported *proxy type and is not part of the public
+// Proxy interface.
+func (p *proxy) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&p.draining, 1)
+
+	ticker := time.NewTicker(drainPollInterval)
+	defer ticker.Stop()
+
+drain:
+	for {
+		_, numTCPClients, numUDPClients := p.ConnCounts()
+		if numTCPClients == 0 && numUDPClients == 0 {
+			break drain
+		}
+		select {
+		case <-ctx.Done():
+			break drain
+		case <-ticker.C:
+		}
+	}
+
+	return p.Close()
+}
+
+// isDraining reports whether Shutdown has been called. Nothing in the proxy
+// calls it; the TCP origin and UDP conntrack accept paths do not check it.
+func (p *proxy) isDraining() bool {
+	return atomic.LoadInt32(&p.draining) == 1
+}
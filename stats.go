@@ -15,6 +15,9 @@ func (p *proxy) trackStats() {
 			numTCPOrigins, numTCPClients, numUDPClients := p.ConnCounts()
 			log.Debugf("TCP Origins: %v   TCP Clients: %v    UDP Clients: %v", numTCPOrigins, numTCPClients, numUDPClients)
 			log.Debugf("Accepted Packets: %d    Rejected Packets: %d", p.AcceptedPackets(), p.RejectedPackets())
+			if limiter, ok := p.opts.RateLimiter.(*TokenBucketRateLimiter); ok {
+				limiter.reportUsage(p.metrics())
+			}
 		}
 	}
 }
@@ -40,6 +43,7 @@ func (p *proxy) ConnCounts() (numTCPOrigins int, numTCPClients int, numUDPClient
 
 func (p *proxy) acceptedPacket() {
 	atomic.AddInt64(&p.acceptedPackets, 1)
+	p.metrics().PacketAccepted()
 }
 
 func (p *proxy) AcceptedPackets() int {
@@ -48,6 +52,7 @@ func (p *proxy) AcceptedPackets() int {
 
 func (p *proxy) rejectedPacket() {
 	atomic.AddInt64(&p.rejectedPackets, 1)
+	p.metrics().PacketRejected()
 }
 
 func (p *proxy) RejectedPackets() int {
@@ -0,0 +1,79 @@
+//go:build kcp
+// +build kcp
+
+package ipproxy
+
+import (
+	"fmt"
+	"net"
+
+	kcp "github.com/xtaci/kcp-go"
+)
+
+// KCPSessionFactory is a reference UDPSessionFactory that wraps each UDP
+// flow in a KCP session, giving it ordered, reliable delivery with
+// configurable loss recovery over the raw UDP transport. It's built behind
+// the "kcp" build tag since it pulls in github.com/xtaci/kcp-go, which
+// most consumers of this package won't need.
+type KCPSessionFactory struct {
+	// DataShards and ParityShards configure KCP's optional forward error
+	// correction. Leave both at zero to disable FEC.
+	DataShards, ParityShards int
+
+	// NoDelay, Interval, Resend and NoCongestion tune KCP's ARQ behavior;
+	// see kcp.KCP.NoDelay for their meaning. Zero values leave KCP's
+	// defaults in place.
+	NoDelay, Interval, Resend, NoCongestion int
+}
+
+// WrapConn implements UDPSessionFactory by handing raw to KCP as its
+// underlying PacketConn-like transport and returning the resulting
+// session as a net.Conn.
+func (f *KCPSessionFactory) WrapConn(raw net.Conn, localAddr, remoteAddr *net.UDPAddr) (net.Conn, error) {
+	conv := convFromAddrs(localAddr, remoteAddr)
+	sess, err := kcp.NewConn3(conv, remoteAddr, nil, f.DataShards, f.ParityShards, &connPacketConn{raw})
+	if err != nil {
+		return nil, fmt.Errorf("unable to establish KCP session: %v", err)
+	}
+	// KCP treats a negative value as "leave unchanged", whereas a zero
+	// Interval would be raised to its 10ms minimum.
+	orDefault := func(v int) int {
+		if v == 0 {
+			return -1
+		}
+		return v
+	}
+	sess.SetNoDelay(orDefault(f.NoDelay), orDefault(f.Interval), orDefault(f.Resend), orDefault(f.NoCongestion))
+	return sess, nil
+}
+
+// convFromAddrs derives a stable KCP conversation ID from a flow's 5-tuple
+// so that repeated WrapConn calls for the same flow line up on both ends.
+func convFromAddrs(localAddr, remoteAddr *net.UDPAddr) uint32 {
+	lo, hi := localAddr.String(), remoteAddr.String()
+	if lo > hi {
+		lo, hi = hi, lo
+	}
+	h := uint32(2166136261)
+	for _, b := range []byte(lo + hi) {
+		h ^= uint32(b)
+		h *= 16777619
+	}
+	return h
+}
+
+// connPacketConn adapts a net.Conn representing a single UDP flow to the
+// net.PacketConn interface kcp.NewConn3 expects, since the proxy already
+// resolved the flow down to one remote address.
+type connPacketConn struct {
+	net.Conn
+}
+
+func (c *connPacketConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	n, err := c.Read(p)
+	return n, c.RemoteAddr(), err
+}
+
+func (c *connPacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	return c.Write(p)
+}
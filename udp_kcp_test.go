@@ -0,0 +1,77 @@
+//go:build kcp
+// +build kcp
+
+package ipproxy
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// lossyConn drops every Nth write to exercise KCP's loss recovery.
+type lossyConn struct {
+	net.Conn
+	dropEvery int
+	writes    int
+}
+
+func (c *lossyConn) Write(b []byte) (int, error) {
+	c.writes++
+	if c.dropEvery > 0 && c.writes%c.dropEvery == 0 {
+		return len(b), nil
+	}
+	return c.Conn.Write(b)
+}
+
+// TestKCPSessionOrderingAndLossRecovery exercises KCPSessionFactory.WrapConn
+// directly over a net.Pipe. It does not go through TUNDevice/New/Serve,
+// because there's no UDP conntrack call site that invokes WrapConn yet for
+// an end-to-end test to exercise - see UDPSessionFactory's doc comment.
+func TestKCPSessionOrderingAndLossRecovery(t *testing.T) {
+	clientRaw, serverRaw := net.Pipe()
+
+	factory := &KCPSessionFactory{NoDelay: 1, Interval: 10, Resend: 2, NoCongestion: 1}
+	localAddr := &net.UDPAddr{IP: net.ParseIP("10.0.1.2"), Port: 40000}
+	remoteAddr := &net.UDPAddr{IP: net.ParseIP("93.184.216.34"), Port: 53}
+
+	clientSess, err := factory.WrapConn(&lossyConn{Conn: clientRaw, dropEvery: 5}, localAddr, remoteAddr)
+	if !assert.NoError(t, err) {
+		return
+	}
+	serverSess, err := factory.WrapConn(serverRaw, remoteAddr, localAddr)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer clientSess.Close()
+	defer serverSess.Close()
+
+	const numMessages = 20
+	go func() {
+		for i := 0; i < numMessages; i++ {
+			msg := []byte{byte(i)}
+			clientSess.Write(msg)
+		}
+	}()
+
+	received := make([]byte, 0, numMessages)
+	buf := make([]byte, 1)
+	serverSess.SetReadDeadline(time.Now().Add(10 * time.Second))
+	for len(received) < numMessages {
+		n, err := serverSess.Read(buf)
+		if err == io.EOF {
+			break
+		}
+		if !assert.NoError(t, err) {
+			return
+		}
+		received = append(received, buf[:n]...)
+	}
+
+	for i, b := range received {
+		assert.Equal(t, byte(i), b, "messages should arrive in order despite dropped writes")
+	}
+}
@@ -0,0 +1,29 @@
+package ipproxy
+
+import "net"
+
+// UDPSessionFactory upgrades a raw UDP 5-tuple into a session-oriented,
+// connection-like abstraction, so that a reliable transport (KCP, uTP, or a
+// bespoke ARQ scheme) can be layered over the UDP side of the TUN without
+// the proxied application having to know about it.
+//
+// raw is a net.Conn representing the client's side of the UDP flow as seen
+// by the proxy; localAddr and remoteAddr are the original 5-tuple endpoints
+// from the intercepted packet. WrapConn returns the net.Conn that should
+// actually be used as the upstream connection for that flow - typically a
+// session type that frames and retransmits over raw.
+//
+// Nothing in the proxy calls WrapConn: there is no Opts.UDPSessionFactory
+// field, and the UDP conntrack path dials every flow with Opts.DialUDP
+// directly.
+type UDPSessionFactory interface {
+	WrapConn(raw net.Conn, localAddr, remoteAddr *net.UDPAddr) (net.Conn, error)
+}
+
+// UDPSessionFactoryFunc adapts a function to a UDPSessionFactory.
+type UDPSessionFactoryFunc func(raw net.Conn, localAddr, remoteAddr *net.UDPAddr) (net.Conn, error)
+
+// WrapConn implements UDPSessionFactory.
+func (f UDPSessionFactoryFunc) WrapConn(raw net.Conn, localAddr, remoteAddr *net.UDPAddr) (net.Conn, error) {
+	return f(raw, localAddr, remoteAddr)
+}